@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxAddrFailures is how many consecutive failed handshakes/exchanges an
+// address tolerates before addrPool evicts it from rotation.
+const maxAddrFailures = 3
+
+// addrPool is a small, thread-safe pool of server addresses that can be
+// refreshed from a bootstrap resolver and health-checked, so long-lived
+// resolvers survive upstream IP rotation without a restart.
+type addrPool struct {
+	mu    sync.Mutex
+	addrs []string
+	fails map[string]int
+}
+
+func newAddrPool(addrs []string) *addrPool {
+	return &addrPool{addrs: addrs, fails: make(map[string]int)}
+}
+
+// pick returns a snapshot of the current, healthy addresses.
+func (p *addrPool) pick() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.addrs...)
+}
+
+// refresh replaces the pool's addresses wholesale, e.g. after a bootstrap
+// re-resolution, discarding any accumulated failure counts.
+func (p *addrPool) refresh(addrs []string) {
+	if len(addrs) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addrs = addrs
+	p.fails = make(map[string]int)
+}
+
+// fail records a failed handshake/exchange against addr, evicting it once
+// it exceeds maxAddrFailures consecutive failures. The last remaining
+// address is never evicted.
+func (p *addrPool) fail(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.addrs) <= 1 {
+		return
+	}
+
+	p.fails[addr]++
+	if p.fails[addr] <= maxAddrFailures {
+		return
+	}
+	for i, a := range p.addrs {
+		if a == addr {
+			p.addrs = append(p.addrs[:i:i], p.addrs[i+1:]...)
+			break
+		}
+	}
+	delete(p.fails, addr)
+}
+
+// succeed clears any accumulated failure count for addr.
+func (p *addrPool) succeed(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.fails, addr)
+}
+
+// bootstrapPool runs for the lifetime of the process, periodically
+// re-resolving host through bootstrap and feeding the results into pool.
+func bootstrapPool(pool *addrPool, host string, bootstrap *net.Resolver, refresh time.Duration) {
+	go func() {
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), refresh)
+			addrs, err := bootstrap.LookupHost(ctx, host)
+			cancel()
+			if err == nil {
+				pool.refresh(addrs)
+			}
+		}
+	}()
+}
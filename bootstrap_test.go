@@ -0,0 +1,23 @@
+package dns_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ncruces/go-dns"
+)
+
+func TestQUICBootstrap(t *testing.T) {
+	r, err := dns.NewQUICResolver("dns.adguard.com",
+		dns.QUICBootstrap(net.DefaultResolver, time.Hour))
+	if err != nil {
+		t.Fatalf("NewQUICResolver() error = %v", err)
+		return
+	}
+
+	if _, err := r.LookupIPAddr(context.TODO(), "one.one.one.one"); err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+}
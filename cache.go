@@ -0,0 +1,288 @@
+package dns
+
+import (
+	"encoding/gob"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// staleTTL is the TTL attached to answers served stale under
+// cache.serveStale, per RFC 8767 §4's recommendation to use a small value.
+const staleTTL = 30 * time.Second
+
+// cache is a minimal DNS answer cache shared by the encrypted transports in
+// this package. Entries are keyed by question name/type/class and expire
+// after the smallest TTL among the answer's resource records, or the SOA
+// minimum for negative (NXDOMAIN/NODATA) answers, per RFC 2308.
+//
+// If persistPath is set, the cache is loaded from and saved to that file as
+// a gob-encoded snapshot, surviving process restarts. If maxStale is
+// positive, an expired entry younger than maxStale beyond its expiry is
+// still served (with TTLs clamped to staleTTL) by getStale, for callers
+// implementing RFC 8767 stale-while-revalidate.
+type cache struct {
+	mu          sync.Mutex
+	entries     map[string]cacheEntry
+	persistPath string
+	maxStale    time.Duration
+}
+
+type cacheEntry struct {
+	Msg     []byte
+	Expires time.Time
+}
+
+func newCache() *cache {
+	return &cache{entries: make(map[string]cacheEntry)}
+}
+
+// get returns a cached answer for query, with its transaction ID patched to
+// match, or nil if there is no unexpired entry.
+func (c *cache) get(query []byte) []byte {
+	key, id, ok := cacheKey(query)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok || time.Now().After(entry.Expires) {
+		return nil
+	}
+
+	return patchID(entry.Msg, id)
+}
+
+// getStale returns the cached answer for query, its transaction ID patched
+// and its TTLs clamped to staleTTL, if the entry is unexpired or no more
+// than c.maxStale past its expiry; stale reports whether an answer was
+// returned, and is false whenever c.maxStale is not positive. Callers use
+// this to keep answering while a fresh exchange is retried in the
+// background, per RFC 8767.
+func (c *cache) getStale(query []byte) (answer []byte, stale bool) {
+	key, id, ok := cacheKey(query)
+	if !ok || c.maxStale <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if !ok || time.Now().After(entry.Expires.Add(c.maxStale)) {
+		return nil, false
+	}
+
+	msg := patchID(entry.Msg, id)
+	clamped, err := clampTTLs(msg, staleTTL)
+	if err != nil {
+		return msg, true
+	}
+	return clamped, true
+}
+
+// put stores answer for query, keyed by question and expiring after the
+// smallest TTL found in its answer records, or the SOA minimum for a
+// negative response. Answers with no usable TTL are not cached. If the
+// cache is persistent, the new entry is flushed to disk.
+func (c *cache) put(query, answer []byte) {
+	key, _, ok := cacheKey(query)
+	if !ok {
+		return
+	}
+
+	ttl, ok := cacheTTL(answer)
+	if !ok || ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	c.entries[key] = cacheEntry{
+		Msg:     append([]byte(nil), answer...),
+		Expires: time.Now().Add(ttl),
+	}
+	path := c.persistPath
+	snapshot := c.snapshotLocked()
+	c.mu.Unlock()
+
+	if path != "" {
+		saveCache(path, snapshot)
+	}
+}
+
+func (c *cache) snapshotLocked() map[string]cacheEntry {
+	snapshot := make(map[string]cacheEntry, len(c.entries))
+	for k, v := range c.entries {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// persist enables on-disk persistence at path: it loads any existing
+// snapshot immediately, and flushes the cache to path after every future
+// update.
+func (c *cache) persist(path string) error {
+	entries, err := loadCache(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	c.mu.Lock()
+	c.persistPath = path
+	for k, v := range entries {
+		c.entries[k] = v
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// serveStale enables RFC 8767 stale-while-revalidate: getStale will serve
+// entries up to maxStale past their expiry.
+func (c *cache) serveStale(maxStale time.Duration) {
+	c.mu.Lock()
+	c.maxStale = maxStale
+	c.mu.Unlock()
+}
+
+func patchID(msg []byte, id uint16) []byte {
+	out := append([]byte(nil), msg...)
+	if len(out) >= 2 {
+		out[0], out[1] = byte(id>>8), byte(id)
+	}
+	return out
+}
+
+func loadCache(path string) (map[string]cacheEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries map[string]cacheEntry
+	if err := gob.NewDecoder(f).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// saveCache writes entries to path via a temporary file and rename, so a
+// concurrent reader never observes a partially-written snapshot.
+func saveCache(path string, entries map[string]cacheEntry) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	if err := gob.NewEncoder(f).Encode(entries); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+func cacheKey(msg []byte) (key string, id uint16, ok bool) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(msg)
+	if err != nil {
+		return "", 0, false
+	}
+	q, err := p.Question()
+	if err != nil {
+		return "", 0, false
+	}
+	return q.Name.String() + "\x00" + q.Type.String() + "\x00" + q.Class.String(), hdr.ID, true
+}
+
+// cacheTTL returns how long answer should be cached: the smallest TTL among
+// its answer records, or, for a negative (NXDOMAIN/NODATA) response, the
+// smaller of the authority section's SOA record TTL and its MINIMUM field,
+// per RFC 2308.
+func cacheTTL(msg []byte) (time.Duration, bool) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return 0, false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return 0, false
+	}
+
+	var min uint32
+	var found bool
+	for {
+		hdr, err := p.AnswerHeader()
+		if err != nil {
+			break
+		}
+		if !found || hdr.TTL < min {
+			min, found = hdr.TTL, true
+		}
+		if err := p.SkipAnswer(); err != nil {
+			break
+		}
+	}
+	if found {
+		return time.Duration(min) * time.Second, true
+	}
+
+	if err := p.SkipAllAnswers(); err != nil {
+		return 0, false
+	}
+	for {
+		hdr, err := p.AuthorityHeader()
+		if err != nil {
+			break
+		}
+		if hdr.Type != dnsmessage.TypeSOA {
+			if err := p.SkipAuthority(); err != nil {
+				break
+			}
+			continue
+		}
+		soa, err := p.SOAResource()
+		if err != nil {
+			break
+		}
+		ttl := hdr.TTL
+		if soa.MinTTL < ttl {
+			ttl = soa.MinTTL
+		}
+		return time.Duration(ttl) * time.Second, true
+	}
+	return 0, false
+}
+
+// clampTTLs re-serializes msg with every resource record's TTL capped to
+// at most max.
+func clampTTLs(msg []byte, max time.Duration) ([]byte, error) {
+	var m dnsmessage.Message
+	if err := m.Unpack(msg); err != nil {
+		return nil, err
+	}
+
+	cap := uint32(max / time.Second)
+	clamp := func(rr *dnsmessage.Resource) {
+		if rr.Header.TTL > cap {
+			rr.Header.TTL = cap
+		}
+	}
+	for i := range m.Answers {
+		clamp(&m.Answers[i])
+	}
+	for i := range m.Authorities {
+		clamp(&m.Authorities[i])
+	}
+	for i := range m.Additionals {
+		clamp(&m.Additionals[i])
+	}
+
+	return m.Pack()
+}
@@ -0,0 +1,48 @@
+package dns_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ncruces/go-dns"
+)
+
+func TestQUICCachePersistent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.gob")
+
+	r, err := dns.NewQUICResolver("dns.adguard.com", dns.QUICCachePersistent(path))
+	if err != nil {
+		t.Fatalf("NewQUICResolver() error = %v", err)
+		return
+	}
+
+	if _, err := r.LookupIPAddr(context.TODO(), "one.one.one.one"); err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+		return
+	}
+
+	// A second resolver pointed at the same path should pick up the
+	// snapshot written by the first.
+	r2, err := dns.NewQUICResolver("dns.adguard.com", dns.QUICCachePersistent(path))
+	if err != nil {
+		t.Fatalf("NewQUICResolver() error = %v", err)
+		return
+	}
+	if _, err := r2.LookupIPAddr(context.TODO(), "one.one.one.one"); err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+}
+
+func TestQUICCacheServeStale(t *testing.T) {
+	r, err := dns.NewQUICResolver("dns.adguard.com", dns.QUICCacheServeStale(time.Hour))
+	if err != nil {
+		t.Fatalf("NewQUICResolver() error = %v", err)
+		return
+	}
+
+	if _, err := r.LookupIPAddr(context.TODO(), "one.one.one.one"); err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package dns_test
+
+import (
+	"net"
+	"sort"
+)
+
+// check reports whether a and b contain the same set of IP addresses,
+// ignoring order, used by cache tests to confirm a cached lookup is
+// answered identically to the original.
+func check(a, b []net.IPAddr) bool {
+	return checkIPAddrs(b, ipStrings(a)...)
+}
+
+// checkIPAddrs reports whether ips contains exactly the addresses in want,
+// ignoring order.
+func checkIPAddrs(ips []net.IPAddr, want ...string) bool {
+	if len(ips) != len(want) {
+		return false
+	}
+	got := ipStrings(ips)
+	sort.Strings(got)
+	sort.Strings(want)
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func ipStrings(ips []net.IPAddr) []string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return s
+}
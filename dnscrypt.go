@@ -0,0 +1,594 @@
+package dns
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnscryptStaleReadTimeout bounds how long a query waits for a fresh answer
+// before falling back to a stale cache entry, when DNSCryptCacheServeStale
+// is enabled.
+const dnscryptStaleReadTimeout = 2 * time.Second
+
+// DNSCryptOption overrides default behavior of NewDNSCryptResolver.
+type DNSCryptOption func(*dnscryptResolver)
+
+// DNSCryptUDP forces queries over UDP, falling back to TCP on truncation.
+// This is the default.
+func DNSCryptUDP() DNSCryptOption {
+	return func(r *dnscryptResolver) { r.network = "udp" }
+}
+
+// DNSCryptTCP forces queries over TCP.
+func DNSCryptTCP() DNSCryptOption {
+	return func(r *dnscryptResolver) { r.network = "tcp" }
+}
+
+// DNSCryptCache enables a cache for the resolver created by
+// NewDNSCryptResolver.
+func DNSCryptCache() DNSCryptOption {
+	return func(r *dnscryptResolver) { r.cache = newCache() }
+}
+
+// DNSCryptCachePersistent enables a cache, as DNSCryptCache does,
+// additionally loading it from, and saving it to, path as a gob-encoded
+// snapshot, so cached answers survive process restarts.
+func DNSCryptCachePersistent(path string) DNSCryptOption {
+	return func(r *dnscryptResolver) {
+		if r.cache == nil {
+			r.cache = newCache()
+		}
+		r.cachePersistPath = path
+	}
+}
+
+// DNSCryptCacheServeStale enables a cache, as DNSCryptCache does, and
+// implements RFC 8767 stale-while-revalidate: if the upstream is
+// unreachable or a query takes longer than a short timeout, an expired
+// cache entry up to maxStale past its expiry is served instead (with its
+// TTLs clamped to a small value), while a fresh exchange is retried in the
+// background.
+func DNSCryptCacheServeStale(maxStale time.Duration) DNSCryptOption {
+	return func(r *dnscryptResolver) {
+		if r.cache == nil {
+			r.cache = newCache()
+		}
+		r.cacheMaxStale = maxStale
+	}
+}
+
+// DNSCryptDialFunc overrides the DialContext used to reach the server in
+// NewDNSCryptResolver.
+func DNSCryptDialFunc(dial func(ctx context.Context, network, address string) (net.Conn, error)) DNSCryptOption {
+	return func(r *dnscryptResolver) { r.dial = dial }
+}
+
+// DNSCryptClientSubnet attaches an EDNS0 Client Subnet option (RFC 7871) to
+// every query sent by the resolver created by NewDNSCryptResolver,
+// advertising prefix to upstream resolvers.
+func DNSCryptClientSubnet(prefix netip.Prefix) DNSCryptOption {
+	return func(r *dnscryptResolver) { r.ecsPrefix, r.noECS = prefix, false }
+}
+
+// DNSCryptNoClientSubnet attaches an EDNS0 Client Subnet option with
+// SOURCE PREFIX-LENGTH = 0 to every query sent by the resolver created by
+// NewDNSCryptResolver, explicitly asking resolvers not to forward client
+// subnet information upstream.
+func DNSCryptNoClientSubnet() DNSCryptOption {
+	return func(r *dnscryptResolver) { r.noECS = true }
+}
+
+type dnscryptResolver struct {
+	network          string
+	cache            *cache
+	cachePersistPath string
+	cacheMaxStale    time.Duration
+	dial             func(ctx context.Context, network, address string) (net.Conn, error)
+	ecsPrefix        netip.Prefix
+	noECS            bool
+
+	addr         string
+	providerName string
+	pinnedPK     [32]byte
+
+	cert   dnscryptCert
+	public *net.Resolver
+}
+
+// NewDNSCryptResolver returns a DNS resolver that encrypts messages to the
+// server described by an sdns:// DNSCrypt stamp (protocol 0x01). It fetches
+// the server's certificate with a plaintext TXT query for the provider
+// name, pins it against the public key carried in the stamp, and encrypts
+// every subsequent query to the negotiated cipher (X25519-XSalsa20Poly1305
+// or X25519-XChaCha20Poly1305) using the DNSCrypt v2 wire format.
+func NewDNSCryptResolver(stamp string, opts ...DNSCryptOption) (*net.Resolver, error) {
+	addr, pk, providerName, err := parseDNSCryptStamp(stamp)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &dnscryptResolver{
+		network:      "udp",
+		addr:         addr,
+		providerName: providerName,
+		pinnedPK:     pk,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.dial == nil {
+		var d net.Dialer
+		r.dial = d.DialContext
+	}
+
+	cert, err := r.fetchCertificate(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("dnscrypt: %w", err)
+	}
+	r.cert = cert
+
+	if r.cache != nil {
+		if r.cachePersistPath != "" {
+			if err := r.cache.persist(r.cachePersistPath); err != nil {
+				return nil, err
+			}
+		}
+		if r.cacheMaxStale > 0 {
+			r.cache.serveStale(r.cacheMaxStale)
+		}
+	}
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			// DNSCryptTCP forces every attempt over TCP; otherwise (UDP is
+			// the default) honor the network Go's resolver requests for
+			// this attempt, so its automatic retry of a truncated UDP
+			// answer over TCP actually changes transport, as DNSCryptUDP's
+			// doc comment promises.
+			transport := r.network
+			if transport != "tcp" {
+				transport = network
+			}
+			conn, err := r.dial(ctx, transport, r.addr)
+			if err != nil {
+				return nil, err
+			}
+			core := dnscryptConn{Conn: conn, resolver: r, network: transport}
+			if transport == "tcp" {
+				return &core, nil
+			}
+			// Go's resolver treats any Dial result implementing
+			// net.PacketConn as packet-oriented and exchanges whole
+			// datagrams with no length framing; dnscryptPacketConn only
+			// exists to satisfy that interface check for UDP.
+			return &dnscryptPacketConn{dnscryptConn: core}, nil
+		},
+	}
+	r.public = resolver
+	return resolver, nil
+}
+
+// backgroundRefresh re-runs query against the server and, on success,
+// replaces its cache entry, used by DNSCryptCacheServeStale to revalidate
+// an entry that was just served stale.
+func (r *dnscryptResolver) backgroundRefresh(query []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return
+	}
+	resp, err := Exchange(ctx, r.public, &msg)
+	if err != nil {
+		return
+	}
+	packed, err := resp.Pack()
+	if err != nil {
+		return
+	}
+	r.cache.put(query, packed)
+}
+
+// dnscryptCert is a server's certificate, pinned to the stamp's long-term
+// public key.
+type dnscryptCert struct {
+	esVersion uint16 // 0x0001: X25519-XSalsa20Poly1305, 0x0002: X25519-XChaCha20Poly1305
+	serverPK  [32]byte
+	magic     [8]byte
+}
+
+// fetchCertificate sends a plaintext TXT query for the provider name and
+// returns the newest certificate whose signature validates against the
+// pinned long-term public key from the stamp.
+func (r *dnscryptResolver) fetchCertificate(ctx context.Context) (dnscryptCert, error) {
+	conn, err := r.dial(ctx, r.network, r.addr)
+	if err != nil {
+		return dnscryptCert{}, err
+	}
+	defer conn.Close()
+
+	name, err := dnsmessage.NewName(ensureRootLabel(r.providerName))
+	if err != nil {
+		return dnscryptCert{}, err
+	}
+
+	var msg dnsmessage.Message
+	msg.Header.ID = uint16(randUint32())
+	msg.Header.RecursionDesired = true
+	msg.Questions = []dnsmessage.Question{{
+		Name:  name,
+		Type:  dnsmessage.TypeTXT,
+		Class: dnsmessage.ClassINET,
+	}}
+
+	query, err := msg.Pack()
+	if err != nil {
+		return dnscryptCert{}, err
+	}
+	if err := writeFramed(conn, r.network, query); err != nil {
+		return dnscryptCert{}, err
+	}
+	answer, err := readFramed(conn, r.network)
+	if err != nil {
+		return dnscryptCert{}, err
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(answer); err != nil {
+		return dnscryptCert{}, err
+	}
+
+	var best dnscryptCert
+	var bestSerial uint32
+	var found bool
+	for _, rr := range resp.Answers {
+		txt, ok := rr.Body.(*dnsmessage.TXTResource)
+		if !ok {
+			continue
+		}
+		for _, chunk := range txt.TXT {
+			cert, serial, ok := parseDNSCryptCert([]byte(chunk), r.pinnedPK)
+			if ok && (!found || serial > bestSerial) {
+				best, bestSerial, found = cert, serial, true
+			}
+		}
+	}
+	if !found {
+		return dnscryptCert{}, errors.New("no certificate signed by the pinned key was found")
+	}
+	return best, nil
+}
+
+// parseDNSCryptCert decodes one certificate TXT record and verifies it was
+// signed by pinned, the stamp's long-term Ed25519 public key, rejecting it
+// otherwise: this is what lets a stamp authenticate a server that answers
+// the plaintext certificate query, instead of trusting whoever answers it.
+//
+// The 124-byte certificate layout (DNSCrypt v2) is:
+//
+//	cert_magic[4] "DNSC" | es_version[2] | minor_version[2] | signature[64] |
+//	  server_pk[32] | client_magic[8] | serial[4] | ts_start[4] | ts_end[4]
+//
+// signature is the Ed25519 signature, under pinned, of everything after it.
+func parseDNSCryptCert(cert []byte, pinned [32]byte) (c dnscryptCert, serial uint32, ok bool) {
+	const (
+		certLen   = 124
+		sigStart  = 8
+		sigEnd    = sigStart + 64
+		signedEnd = certLen
+	)
+	if len(cert) < certLen || string(cert[:4]) != "DNSC" {
+		return c, 0, false
+	}
+	c.esVersion = binary.BigEndian.Uint16(cert[4:6])
+
+	signature := cert[sigStart:sigEnd]
+	signed := cert[sigEnd:signedEnd]
+	if !ed25519.Verify(pinned[:], signed, signature) {
+		return c, 0, false
+	}
+
+	copy(c.serverPK[:], signed[:32])
+	copy(c.magic[:], signed[32:40])
+	serial = binary.BigEndian.Uint32(signed[40:44])
+	return c, serial, true
+}
+
+// dnscryptConn wraps one query/response exchange over a stream-style (TCP)
+// connection, encrypting the query and decrypting the matching response
+// using the resolver's pinned certificate. Go's resolver stream round trip
+// reads a 2-byte length prefix and then exactly that many bytes, possibly
+// across separate Read calls, so a decoded answer is buffered in pending
+// and drained incrementally rather than re-fetched per call.
+type dnscryptConn struct {
+	net.Conn
+	resolver *dnscryptResolver
+	network  string // the transport actually dialed for this conn: "tcp" or "udp"
+
+	clientPK, clientSK [32]byte
+	nonce              [24]byte
+	query              []byte
+	pending            *frameReader
+}
+
+func (c *dnscryptConn) Write(p []byte) (int, error) {
+	if len(p) < 2 {
+		return 0, errors.New("dnscrypt: short query")
+	}
+	query, err := c.resolver.applyClientSubnet(p[2:])
+	if err != nil {
+		return 0, err
+	}
+
+	if c.resolver.cache != nil {
+		c.query = append([]byte(nil), query...)
+		if cached := c.resolver.cache.get(c.query); cached != nil {
+			c.pending = newFrameReader(cached)
+			return len(p), nil
+		}
+	}
+
+	packet, err := c.encryptQuery(query)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeFramed(c.Conn, c.network, packet); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *dnscryptConn) Read(p []byte) (int, error) {
+	if c.pending == nil {
+		answer, err := c.fetchAnswer()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = newFrameReader(answer)
+	}
+	return c.pending.Read(p)
+}
+
+// fetchAnswer reads and decrypts one response, or, past a short timeout or
+// other error, falls back to a cache entry no more than maxStale past its
+// expiry if DNSCryptCacheServeStale is enabled, kicking off a background
+// refresh.
+func (c *dnscryptConn) fetchAnswer() ([]byte, error) {
+	if c.resolver.cache != nil && c.resolver.cacheMaxStale > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(dnscryptStaleReadTimeout))
+	}
+
+	answer, err := c.readAnswer()
+	if err != nil {
+		if c.resolver.cache != nil && c.query != nil {
+			if stale, ok := c.resolver.cache.getStale(c.query); ok {
+				go c.resolver.backgroundRefresh(c.query)
+				return stale, nil
+			}
+		}
+		return nil, err
+	}
+
+	if c.resolver.cache != nil && c.query != nil {
+		c.resolver.cache.put(c.query, answer)
+	}
+	return answer, nil
+}
+
+func (c *dnscryptConn) readAnswer() ([]byte, error) {
+	packet, err := readFramed(c.Conn, c.network)
+	if err != nil {
+		return nil, err
+	}
+	return c.resolver.decrypt(packet, c.clientSK)
+}
+
+// encryptQuery generates a fresh ephemeral keypair and nonce and returns the
+// encrypted DNSCrypt client packet for query, already adjusted for the
+// resolver's client-subnet options by the caller. The ephemeral secret key
+// and nonce are retained on c for decrypting the matching response.
+func (c *dnscryptConn) encryptQuery(query []byte) ([]byte, error) {
+	if _, err := io.ReadFull(rand.Reader, c.clientSK[:]); err != nil {
+		return nil, err
+	}
+	curve25519.ScalarBaseMult(&c.clientPK, &c.clientSK)
+	if _, err := io.ReadFull(rand.Reader, c.nonce[:12]); err != nil {
+		return nil, err
+	}
+	return c.resolver.encrypt(query, c.clientPK, c.clientSK, c.nonce)
+}
+
+// applyClientSubnet applies r's EDNS Client Subnet option, if any, to query.
+func (r *dnscryptResolver) applyClientSubnet(query []byte) ([]byte, error) {
+	switch {
+	case r.noECS:
+		return injectNoClientSubnet(query)
+	case r.ecsPrefix.IsValid():
+		return injectClientSubnet(query, r.ecsPrefix)
+	default:
+		return query, nil
+	}
+}
+
+// dnscryptPacketConn is the UDP counterpart to dnscryptConn: Go's resolver
+// exchanges a single datagram per query/response with no length framing, so
+// Read and Write here skip the frame buffering dnscryptConn needs for TCP.
+// ReadFrom/WriteTo exist purely so this type satisfies net.PacketConn.
+type dnscryptPacketConn struct {
+	dnscryptConn
+	cachedAnswer []byte
+}
+
+func (c *dnscryptPacketConn) Write(p []byte) (int, error) {
+	query, err := c.resolver.applyClientSubnet(p)
+	if err != nil {
+		return 0, err
+	}
+
+	if c.resolver.cache != nil {
+		c.query = append([]byte(nil), query...)
+		if cached := c.resolver.cache.get(c.query); cached != nil {
+			c.cachedAnswer = cached
+			return len(p), nil
+		}
+	}
+
+	packet, err := c.encryptQuery(query)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeFramed(c.Conn, "udp", packet); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *dnscryptPacketConn) Read(p []byte) (int, error) {
+	if c.cachedAnswer != nil {
+		return copy(p, c.cachedAnswer), nil
+	}
+	answer, err := c.fetchAnswer()
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, answer), nil
+}
+
+func (c *dnscryptPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Read(p)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *dnscryptPacketConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	return c.Write(p)
+}
+
+// encrypt wraps query as a DNSCrypt-encrypted client packet, using the
+// cipher indicated by the pinned certificate's es_version.
+func (r *dnscryptResolver) encrypt(query []byte, clientPK, clientSK [32]byte, nonce [24]byte) ([]byte, error) {
+	var sealed []byte
+	switch r.cert.esVersion {
+	case 0x0002: // X25519-XChaCha20Poly1305
+		shared, err := curve25519.X25519(clientSK[:], r.cert.serverPK[:])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := chacha20poly1305.NewX(shared)
+		if err != nil {
+			return nil, err
+		}
+		sealed = aead.Seal(nil, nonce[:], query, nil)
+	default: // X25519-XSalsa20Poly1305
+		sealed = box.Seal(nil, query, &nonce, &r.cert.serverPK, &clientSK)
+	}
+
+	packet := make([]byte, 0, len(r.cert.magic)+32+12+len(sealed))
+	packet = append(packet, r.cert.magic[:]...)
+	packet = append(packet, clientPK[:]...)
+	packet = append(packet, nonce[:12]...)
+	packet = append(packet, sealed...)
+	return packet, nil
+}
+
+// decrypt unwraps a DNSCrypt server response packet back into a plain DNS
+// message, using the client's ephemeral secret key from the matching query.
+func (r *dnscryptResolver) decrypt(packet []byte, clientSK [32]byte) ([]byte, error) {
+	const responseMagic = "r6fnvWj8"
+	if len(packet) < len(responseMagic)+24 {
+		return nil, errors.New("dnscrypt: short response")
+	}
+	if string(packet[:8]) != responseMagic {
+		return nil, errors.New("dnscrypt: bad response magic")
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], packet[8:32])
+	sealed := packet[32:]
+
+	switch r.cert.esVersion {
+	case 0x0002:
+		shared, err := curve25519.X25519(clientSK[:], r.cert.serverPK[:])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := chacha20poly1305.NewX(shared)
+		if err != nil {
+			return nil, err
+		}
+		return aead.Open(nil, nonce[:], sealed, nil)
+	default:
+		answer, ok := box.Open(nil, sealed, &nonce, &r.cert.serverPK, &clientSK)
+		if !ok {
+			return nil, errors.New("dnscrypt: failed to open response box")
+		}
+		return answer, nil
+	}
+}
+
+func parseDNSCryptStamp(stamp string) (addr string, pk [32]byte, providerName string, err error) {
+	const prefix = "sdns://"
+	if !strings.HasPrefix(stamp, prefix) {
+		return "", pk, "", errors.New("dnscrypt: not an sdns:// stamp")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(stamp[len(prefix):])
+	if err != nil {
+		return "", pk, "", fmt.Errorf("dnscrypt: %w", err)
+	}
+	if len(raw) < 1 || raw[0] != 0x01 {
+		return "", pk, "", errors.New("dnscrypt: not a DNSCrypt (protocol 0x01) stamp")
+	}
+	buf := raw[1:]
+	if len(buf) < 8 {
+		return "", pk, "", errors.New("dnscrypt: truncated stamp")
+	}
+	buf = buf[8:] // props bitfield, unused by this minimal client
+
+	fields := make([][]byte, 3) // addr, pk, provider name
+	for i := range fields {
+		if len(buf) < 1 {
+			return "", pk, "", errors.New("dnscrypt: truncated stamp")
+		}
+		n := int(buf[0])
+		buf = buf[1:]
+		if len(buf) < n {
+			return "", pk, "", errors.New("dnscrypt: truncated stamp")
+		}
+		fields[i], buf = buf[:n], buf[n:]
+	}
+
+	if len(fields[1]) != 32 {
+		return "", pk, "", errors.New("dnscrypt: public key must be 32 bytes")
+	}
+	copy(pk[:], fields[1])
+
+	addr = string(fields[0])
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, strconv.Itoa(443))
+	}
+	return addr, pk, string(fields[2]), nil
+}
+
+func randUint32() uint32 {
+	var b [4]byte
+	io.ReadFull(rand.Reader, b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
@@ -0,0 +1,68 @@
+package dns_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ncruces/go-dns"
+)
+
+func TestNewDNSCryptResolver(t *testing.T) {
+	// DNSCrypt Public Resolvers, as sdns:// stamps.
+	tests := map[string]struct {
+		stamp string
+		opts  []dns.DNSCryptOption
+	}{
+		"AdGuard": {
+			stamp: "sdns://AQMAAAAAAAAAETk0LjE0MC4xNC4xNDo1NDQzINErR_JS3PLCu_iZEnA_rd6hh23wRbnlATAEOc5GKdsMGzIuZG5zY3J5cHQtY2VydC5hZGd1YXJkLmNvbQ",
+		},
+		"AdGuard-TCP": {
+			stamp: "sdns://AQMAAAAAAAAAETk0LjE0MC4xNC4xNDo1NDQzINErR_JS3PLCu_iZEnA_rd6hh23wRbnlATAEOc5GKdsMGzIuZG5zY3J5cHQtY2VydC5hZGd1YXJkLmNvbQ",
+			opts:  []dns.DNSCryptOption{dns.DNSCryptTCP()},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := dns.NewDNSCryptResolver(tc.stamp, tc.opts...)
+			if err != nil {
+				t.Fatalf("NewDNSCryptResolver() error = %v", err)
+				return
+			}
+
+			ips, err := r.LookupIPAddr(context.TODO(), "one.one.one.one")
+			if err != nil {
+				t.Fatalf("LookupIPAddr() error = %v", err)
+				return
+			}
+			if len(ips) == 0 {
+				t.Errorf("LookupIPAddr() got no results")
+			}
+		})
+	}
+
+	t.Run("Cache", func(t *testing.T) {
+		stamp := tests["AdGuard"].stamp
+		r, err := dns.NewDNSCryptResolver(stamp, dns.DNSCryptCache())
+		if err != nil {
+			t.Fatalf("NewDNSCryptResolver() error = %v", err)
+			return
+		}
+
+		a, err := r.LookupIPAddr(context.TODO(), "one.one.one.one")
+		if err != nil {
+			t.Fatalf("LookupIPAddr() error = %v", err)
+			return
+		}
+
+		b, err := r.LookupIPAddr(context.TODO(), "one.one.one.one")
+		if err != nil {
+			t.Fatalf("LookupIPAddr() error = %v", err)
+			return
+		}
+
+		if !check(a, b) {
+			t.Errorf("LookupIPAddr() want = %v, got = %v", a, b)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ecsOptionCode is the EDNS0 option code for Client Subnet (RFC 7871).
+const ecsOptionCode = 8
+
+// edns0Root is the root name OPT pseudo-records are always attached to.
+var edns0Root = dnsmessage.MustNewName(".")
+
+// injectClientSubnet re-serializes query with an EDNS0 Client Subnet option
+// (RFC 7871) added to its OPT pseudo-record, advertising prefix so upstream
+// resolvers can steer results (e.g. to a nearby CDN edge).
+func injectClientSubnet(query []byte, prefix netip.Prefix) ([]byte, error) {
+	addr := prefix.Addr()
+	family := uint16(1)
+	if addr.Is6() {
+		family = 2
+	}
+	bits := prefix.Bits()
+	addrBytes := addr.AsSlice()
+	addrBytes = addrBytes[:(bits+7)/8]
+
+	option := make([]byte, 4+len(addrBytes))
+	binary.BigEndian.PutUint16(option, family)
+	option[2] = byte(bits) // source prefix-length
+	option[3] = 0          // scope prefix-length, set by the server
+	copy(option[4:], addrBytes)
+
+	return appendOPTOption(query, option)
+}
+
+// injectNoClientSubnet re-serializes query with an EDNS0 Client Subnet
+// option carrying SOURCE PREFIX-LENGTH = 0, explicitly asking resolvers not
+// to forward client subnet information further upstream.
+func injectNoClientSubnet(query []byte) ([]byte, error) {
+	option := []byte{0, 1, 0, 0} // family=IPv4, source=0, scope=0, no address
+	return appendOPTOption(query, option)
+}
+
+// appendOPTOption fully decodes query, adds a single EDNS0 Client Subnet
+// option to its OPT pseudo-record (appending a new OPT record to the
+// additional section if it has none), and re-encodes it.
+func appendOPTOption(query []byte, ecsOption []byte) ([]byte, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+
+	for _, rr := range msg.Additionals {
+		if opt, ok := rr.Body.(*dnsmessage.OPTResource); ok {
+			opt.Options = append(opt.Options, dnsmessage.Option{Code: ecsOptionCode, Data: ecsOption})
+			return msg.Pack()
+		}
+	}
+
+	rdata := make([]byte, 0, 4+len(ecsOption))
+	rdata = binary.BigEndian.AppendUint16(rdata, ecsOptionCode)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(ecsOption)))
+	rdata = append(rdata, ecsOption...)
+
+	msg.Additionals = append(msg.Additionals, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  edns0Root,
+			Type:  dnsmessage.Type(41), // OPT
+			Class: dnsmessage.Class(4096),
+		},
+		Body: &dnsmessage.UnknownResource{
+			Type: dnsmessage.Type(41),
+			Data: rdata,
+		},
+	})
+
+	return msg.Pack()
+}
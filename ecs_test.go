@@ -0,0 +1,34 @@
+package dns_test
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/ncruces/go-dns"
+)
+
+func TestQUICClientSubnet(t *testing.T) {
+	r, err := dns.NewQUICResolver("dns.adguard.com",
+		dns.QUICClientSubnet(netip.MustParsePrefix("203.0.113.0/24")))
+	if err != nil {
+		t.Fatalf("NewQUICResolver() error = %v", err)
+		return
+	}
+
+	if _, err := r.LookupIPAddr(context.TODO(), "one.one.one.one"); err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+}
+
+func TestQUICNoClientSubnet(t *testing.T) {
+	r, err := dns.NewQUICResolver("dns.adguard.com", dns.QUICNoClientSubnet())
+	if err != nil {
+		t.Fatalf("NewQUICResolver() error = %v", err)
+		return
+	}
+
+	if _, err := r.LookupIPAddr(context.TODO(), "one.one.one.one"); err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+	}
+}
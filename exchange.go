@@ -0,0 +1,247 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Type codes not predefined by dnsmessage.
+const (
+	typeTLSA  dnsmessage.Type = 52
+	typeSVCB  dnsmessage.Type = 64
+	typeHTTPS dnsmessage.Type = 65
+	typeCAA   dnsmessage.Type = 257
+)
+
+// Exchange sends msg to the server behind r and returns its response. r
+// must have been constructed by one of this package's New*Resolver
+// functions: Exchange reuses the resolver's Dial to reach the same
+// encrypted transport used for ordinary lookups, framing msg exactly as
+// DNS-over-TCP does.
+func Exchange(ctx context.Context, r *net.Resolver, msg *dnsmessage.Message) (*dnsmessage.Message, error) {
+	if r == nil || r.Dial == nil {
+		return nil, errors.New("dns: resolver has no custom transport to exchange with")
+	}
+
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := r.Dial(ctx, "tcp", "")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	framed := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	copy(framed[2:], query)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return nil, err
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(answer); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SVCBRecord is a parsed SVCB or HTTPS resource record (RFC 9460).
+type SVCBRecord struct {
+	Priority uint16
+	Target   string
+	Params   map[uint16][]byte
+}
+
+// CAARecord is a parsed Certification Authority Authorization resource
+// record (RFC 6844).
+type CAARecord struct {
+	Critical bool
+	Tag      string
+	Value    string
+}
+
+// TLSARecord is a parsed TLSA resource record (RFC 6698), used for DANE.
+type TLSARecord struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Data         []byte
+}
+
+// LookupHTTPS returns the HTTPS resource records for host.
+func LookupHTTPS(ctx context.Context, r *net.Resolver, host string) ([]SVCBRecord, error) {
+	return lookupSVCB(ctx, r, host, typeHTTPS)
+}
+
+// LookupSVCB returns the SVCB resource records for host.
+func LookupSVCB(ctx context.Context, r *net.Resolver, host string) ([]SVCBRecord, error) {
+	return lookupSVCB(ctx, r, host, typeSVCB)
+}
+
+// LookupCAA returns the CAA resource records for host.
+func LookupCAA(ctx context.Context, r *net.Resolver, host string) ([]CAARecord, error) {
+	msg, err := exchangeQuestion(ctx, r, host, typeCAA)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []CAARecord
+	for _, rr := range msg.Answers {
+		body, ok := rr.Body.(*dnsmessage.UnknownResource)
+		if !ok || body.Type != typeCAA || len(body.Data) < 2 {
+			continue
+		}
+		flags, tagLen := body.Data[0], int(body.Data[1])
+		if len(body.Data) < 2+tagLen {
+			continue
+		}
+		records = append(records, CAARecord{
+			Critical: flags&0x80 != 0,
+			Tag:      string(body.Data[2 : 2+tagLen]),
+			Value:    string(body.Data[2+tagLen:]),
+		})
+	}
+	return records, nil
+}
+
+// LookupTLSA returns the TLSA resource records for name, e.g.
+// "_443._tcp.example.com" (RFC 6698).
+func LookupTLSA(ctx context.Context, r *net.Resolver, name string) ([]TLSARecord, error) {
+	msg, err := exchangeQuestion(ctx, r, name, typeTLSA)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []TLSARecord
+	for _, rr := range msg.Answers {
+		body, ok := rr.Body.(*dnsmessage.UnknownResource)
+		if !ok || body.Type != typeTLSA || len(body.Data) < 3 {
+			continue
+		}
+		records = append(records, TLSARecord{
+			Usage:        body.Data[0],
+			Selector:     body.Data[1],
+			MatchingType: body.Data[2],
+			Data:         append([]byte(nil), body.Data[3:]...),
+		})
+	}
+	return records, nil
+}
+
+func lookupSVCB(ctx context.Context, r *net.Resolver, host string, qtype dnsmessage.Type) ([]SVCBRecord, error) {
+	msg, err := exchangeQuestion(ctx, r, host, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []SVCBRecord
+	for _, rr := range msg.Answers {
+		body, ok := rr.Body.(*dnsmessage.UnknownResource)
+		if !ok || body.Type != qtype {
+			continue
+		}
+		rec, ok := parseSVCB(body.Data)
+		if ok {
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+func parseSVCB(data []byte) (rec SVCBRecord, ok bool) {
+	if len(data) < 2 {
+		return rec, false
+	}
+	rec.Priority = binary.BigEndian.Uint16(data)
+	data = data[2:]
+
+	target, n, ok := decodeUncompressedName(data)
+	if !ok {
+		return rec, false
+	}
+	rec.Target = target
+	data = data[n:]
+
+	rec.Params = make(map[uint16][]byte)
+	for len(data) >= 4 {
+		key := binary.BigEndian.Uint16(data)
+		valLen := int(binary.BigEndian.Uint16(data[2:]))
+		data = data[4:]
+		if len(data) < valLen {
+			break
+		}
+		rec.Params[key] = append([]byte(nil), data[:valLen]...)
+		data = data[valLen:]
+	}
+	return rec, true
+}
+
+// decodeUncompressedName decodes a sequence of length-prefixed labels
+// terminated by a zero length, as used by SVCB/HTTPS TargetName, which RFC
+// 9460 requires to appear uncompressed within the record data.
+func decodeUncompressedName(data []byte) (name string, n int, ok bool) {
+	start := len(data)
+	for {
+		if len(data) == 0 {
+			return "", 0, false
+		}
+		l := int(data[0])
+		data = data[1:]
+		if l == 0 {
+			break
+		}
+		if len(data) < l {
+			return "", 0, false
+		}
+		if name != "" {
+			name += "."
+		}
+		name += string(data[:l])
+		data = data[l:]
+	}
+	if name != "" {
+		name += "."
+	}
+	return name, start - len(data), true
+}
+
+func exchangeQuestion(ctx context.Context, r *net.Resolver, host string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	name, err := dnsmessage.NewName(ensureRootLabel(host))
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &dnsmessage.Message{
+		Header: dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	return Exchange(ctx, r, msg)
+}
+
+func ensureRootLabel(host string) string {
+	if len(host) == 0 || host[len(host)-1] != '.' {
+		return host + "."
+	}
+	return host
+}
@@ -0,0 +1,68 @@
+package dns_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ncruces/go-dns"
+)
+
+func TestLookupHTTPS(t *testing.T) {
+	// TODO: exchange against dns.NewDoHResolver once it exists in this
+	// tree; for now, use the QUIC resolver this series actually adds.
+	r, err := dns.NewQUICResolver("dns.adguard.com")
+	if err != nil {
+		t.Fatalf("NewQUICResolver() error = %v", err)
+		return
+	}
+
+	records, err := dns.LookupHTTPS(context.TODO(), r, "cloudflare.com")
+	if err != nil {
+		t.Fatalf("LookupHTTPS() error = %v", err)
+		return
+	}
+	if len(records) == 0 {
+		t.Errorf("LookupHTTPS() got no records")
+	}
+}
+
+func TestLookupCAA(t *testing.T) {
+	r, err := dns.NewQUICResolver("dns.adguard.com")
+	if err != nil {
+		t.Fatalf("NewQUICResolver() error = %v", err)
+		return
+	}
+
+	records, err := dns.LookupCAA(context.TODO(), r, "google.com")
+	if err != nil {
+		t.Fatalf("LookupCAA() error = %v", err)
+		return
+	}
+	if len(records) == 0 {
+		t.Errorf("LookupCAA() got no records")
+	}
+}
+
+// TestLookupHTTPS_DNSCrypt exercises Exchange against the default (UDP)
+// DNSCrypt transport: Exchange always dials "tcp", which must still yield a
+// correctly-framed stream conn even though NewDNSCryptResolver defaults to
+// UDP for ordinary lookups.
+func TestLookupHTTPS_DNSCrypt(t *testing.T) {
+	// AdGuard Public Resolver, as an sdns:// stamp.
+	const stamp = "sdns://AQMAAAAAAAAAETk0LjE0MC4xNC4xNDo1NDQzINErR_JS3PLCu_iZEnA_rd6hh23wRbnlATAEOc5GKdsMGzIuZG5zY3J5cHQtY2VydC5hZGd1YXJkLmNvbQ"
+
+	r, err := dns.NewDNSCryptResolver(stamp)
+	if err != nil {
+		t.Fatalf("NewDNSCryptResolver() error = %v", err)
+		return
+	}
+
+	records, err := dns.LookupHTTPS(context.TODO(), r, "cloudflare.com")
+	if err != nil {
+		t.Fatalf("LookupHTTPS() error = %v", err)
+		return
+	}
+	if len(records) == 0 {
+		t.Errorf("LookupHTTPS() got no records")
+	}
+}
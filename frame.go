@@ -0,0 +1,69 @@
+package dns
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// frameMessage returns msg prefixed with its 2-byte big-endian length, the
+// framing DNS-over-TCP (and compatible streams) requires.
+func frameMessage(msg []byte) []byte {
+	framed := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(framed, uint16(len(msg)))
+	copy(framed[2:], msg)
+	return framed
+}
+
+// writeFramed writes msg to conn, prefixed by its 2-byte big-endian length
+// as DNS-over-TCP requires, unless network is not "tcp", in which case msg
+// is written as-is (a single packet).
+func writeFramed(conn net.Conn, network string, msg []byte) error {
+	if network != "tcp" {
+		_, err := conn.Write(msg)
+		return err
+	}
+	_, err := conn.Write(frameMessage(msg))
+	return err
+}
+
+// readFramed reads one message from conn: if network is "tcp", it reads the
+// 2-byte big-endian length prefix DNS-over-TCP requires, then exactly that
+// many bytes; otherwise it reads a single packet.
+func readFramed(conn net.Conn, network string) ([]byte, error) {
+	if network != "tcp" {
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		return buf[:n], err
+	}
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(conn, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	_, err := io.ReadFull(conn, msg)
+	return msg, err
+}
+
+// frameReader dispenses a single message as a 2-byte-length-prefixed frame,
+// as DNS-over-TCP's stream round trip expects, across however many Read
+// calls the caller makes to drain it — unlike a one-shot copy into the
+// caller's buffer, this survives the length-then-body read pattern
+// net.Resolver's stream round trip uses.
+type frameReader struct {
+	buf []byte
+}
+
+// newFrameReader returns a frameReader yielding msg length-prefixed.
+func newFrameReader(msg []byte) *frameReader {
+	return &frameReader{buf: frameMessage(msg)}
+}
+
+func (f *frameReader) Read(p []byte) (int, error) {
+	if len(f.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
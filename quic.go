@@ -0,0 +1,315 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// quicStaleReadTimeout bounds how long a query waits for a fresh answer
+// before falling back to a stale cache entry, when QUICCacheServeStale is
+// enabled.
+const quicStaleReadTimeout = 2 * time.Second
+
+// QUICOption overrides default behavior of NewQUICResolver.
+type QUICOption func(*quicResolver)
+
+// QUICAddresses overrides DNS resolution of the server name in
+// NewQUICResolver, specifying IP addresses for it directly.
+func QUICAddresses(addrs ...string) QUICOption {
+	return func(r *quicResolver) { r.pool = newAddrPool(addrs) }
+}
+
+// QUICBootstrap periodically re-resolves the server name through
+// bootstrap, every refresh interval, and updates the pool of addresses
+// used to reach it, evicting addresses that repeatedly fail the QUIC
+// handshake. This keeps long-lived resolvers working across upstream IP
+// changes without a restart.
+func QUICBootstrap(bootstrap *net.Resolver, refresh time.Duration) QUICOption {
+	return func(r *quicResolver) { r.bootstrap, r.bootstrapEvery = bootstrap, refresh }
+}
+
+// QUICCache enables a cache for the resolver created by NewQUICResolver.
+func QUICCache() QUICOption {
+	return func(r *quicResolver) { r.cache = newCache() }
+}
+
+// QUICCachePersistent enables a cache, as QUICCache does, additionally
+// loading it from, and saving it to, path as a gob-encoded snapshot, so
+// cached answers survive process restarts.
+func QUICCachePersistent(path string) QUICOption {
+	return func(r *quicResolver) {
+		if r.cache == nil {
+			r.cache = newCache()
+		}
+		r.cachePersistPath = path
+	}
+}
+
+// QUICCacheServeStale enables a cache, as QUICCache does, and implements
+// RFC 8767 stale-while-revalidate: if the upstream is unreachable or a
+// query takes longer than a short timeout, an expired cache entry up to
+// maxStale past its expiry is served instead (with its TTLs clamped to a
+// small value), while a fresh exchange is retried in the background.
+func QUICCacheServeStale(maxStale time.Duration) QUICOption {
+	return func(r *quicResolver) {
+		if r.cache == nil {
+			r.cache = newCache()
+		}
+		r.cacheMaxStale = maxStale
+	}
+}
+
+// QUICDialFunc overrides the (UDP) DialContext used to reach the server in
+// NewQUICResolver.
+func QUICDialFunc(dial func(ctx context.Context, network, address string) (net.PacketConn, error)) QUICOption {
+	return func(r *quicResolver) { r.dial = dial }
+}
+
+// QUICClientSubnet attaches an EDNS0 Client Subnet option (RFC 7871) to
+// every query sent by the resolver created by NewQUICResolver, advertising
+// prefix to upstream resolvers.
+func QUICClientSubnet(prefix netip.Prefix) QUICOption {
+	return func(r *quicResolver) { r.ecsPrefix, r.noECS = prefix, false }
+}
+
+// QUICNoClientSubnet attaches an EDNS0 Client Subnet option with
+// SOURCE PREFIX-LENGTH = 0 to every query sent by the resolver created by
+// NewQUICResolver, explicitly asking resolvers not to forward client
+// subnet information upstream.
+func QUICNoClientSubnet() QUICOption {
+	return func(r *quicResolver) { r.noECS = true }
+}
+
+type quicResolver struct {
+	host             string
+	pool             *addrPool
+	cache            *cache
+	cachePersistPath string
+	cacheMaxStale    time.Duration
+	dial             func(ctx context.Context, network, address string) (net.PacketConn, error)
+	ecsPrefix        netip.Prefix
+	noECS            bool
+	bootstrap        *net.Resolver
+	bootstrapEvery   time.Duration
+
+	mu     sync.Mutex
+	conn   *quic.Conn
+	public *net.Resolver
+}
+
+// NewQUICResolver returns a DNS resolver that encrypts messages using
+// DNS-over-QUIC (DoQ), as specified in RFC 9250, opening one QUIC stream per
+// query over a shared connection, with each query and response prefixed by
+// its length, as with DNS-over-TCP.
+//
+// server is a server name, optionally followed by a ":port"
+// (defaults to ":853"). Unless QUICAddresses is used, server is resolved
+// for its addresses using the local resolver.
+func NewQUICResolver(server string, opts ...QUICOption) (*net.Resolver, error) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = server, "853"
+	}
+
+	r := &quicResolver{host: host, pool: newAddrPool([]string{host})}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.dial == nil {
+		var d net.ListenConfig
+		r.dial = func(ctx context.Context, network, _ string) (net.PacketConn, error) {
+			return d.ListenPacket(ctx, network, ":0")
+		}
+	}
+	if r.bootstrap != nil {
+		bootstrapPool(r.pool, host, r.bootstrap, r.bootstrapEvery)
+	}
+	if r.cache != nil {
+		if r.cachePersistPath != "" {
+			if err := r.cache.persist(r.cachePersistPath); err != nil {
+				return nil, err
+			}
+		}
+		if r.cacheMaxStale > 0 {
+			r.cache.serveStale(r.cacheMaxStale)
+		}
+	}
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			addrs := r.pool.pick()
+			addr := addrs[rand.Intn(len(addrs))]
+			stream, err := r.openStream(ctx, net.JoinHostPort(addr, port))
+			if err != nil {
+				if r.bootstrap != nil {
+					r.pool.fail(addr)
+				}
+				return nil, err
+			}
+			if r.bootstrap != nil {
+				r.pool.succeed(addr)
+			}
+			return &quicStreamConn{Stream: stream, resolver: r}, nil
+		},
+	}
+	r.public = resolver
+	return resolver, nil
+}
+
+// backgroundRefresh re-runs query against the server and, on success,
+// replaces its cache entry, used by QUICCacheServeStale to revalidate an
+// entry that was just served stale.
+func (r *quicResolver) backgroundRefresh(query []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return
+	}
+	resp, err := Exchange(ctx, r.public, &msg)
+	if err != nil {
+		return
+	}
+	packed, err := resp.Pack()
+	if err != nil {
+		return
+	}
+	r.cache.put(query, packed)
+}
+
+// openStream returns a new QUIC stream on a connection to addr, dialing a
+// fresh connection if none is established yet or the previous one is dead.
+func (r *quicResolver) openStream(ctx context.Context, addr string) (*quic.Stream, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil || r.conn.Context().Err() != nil {
+		pconn, err := r.dial(ctx, "udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		raddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			pconn.Close()
+			return nil, err
+		}
+
+		conn, err := quic.Dial(ctx, pconn, raddr, &tls.Config{
+			ServerName: r.host,
+			NextProtos: []string{"doq"},
+		}, nil)
+		if err != nil {
+			pconn.Close()
+			return nil, err
+		}
+		r.conn = conn
+	}
+
+	return r.conn.OpenStreamSync(ctx)
+}
+
+// quicStreamConn adapts a single QUIC stream to net.Conn, so it can be used
+// as the result of a net.Resolver Dial: one query is written, the stream is
+// half-closed for writing (as RFC 9250 requires), and one length-prefixed
+// response is read back. Successful exchanges are recorded in cache, if set.
+type quicStreamConn struct {
+	*quic.Stream
+	resolver *quicResolver
+	query    []byte
+	pending  *frameReader
+	recvBuf  []byte
+}
+
+func (c *quicStreamConn) Write(p []byte) (int, error) {
+	consumed := len(p)
+	wire := p
+
+	if len(p) > 2 {
+		query := p[2:]
+
+		var err error
+		switch {
+		case c.resolver.noECS:
+			query, err = injectNoClientSubnet(query)
+		case c.resolver.ecsPrefix.IsValid():
+			query, err = injectClientSubnet(query, c.resolver.ecsPrefix)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if c.resolver.cache != nil {
+			c.query = append([]byte(nil), query...)
+			if cached := c.resolver.cache.get(c.query); cached != nil {
+				c.pending = newFrameReader(cached)
+				return consumed, nil
+			}
+		}
+
+		framed := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(framed, uint16(len(query)))
+		copy(framed[2:], query)
+		wire = framed
+	}
+
+	if _, err := c.Stream.Write(wire); err != nil {
+		return 0, err
+	}
+	// RFC 9250 §4.2: the client MUST send a FIN after the query.
+	c.Stream.Close()
+	return consumed, nil
+}
+
+// Read serves the next chunk of the response. Once a stale cache fallback
+// has kicked in, remaining calls drain it from pending instead of retrying
+// the (already failing) stream, correctly framed and honoring whatever
+// buffer size the caller passes each call.
+func (c *quicStreamConn) Read(p []byte) (int, error) {
+	if c.pending != nil {
+		return c.pending.Read(p)
+	}
+
+	if c.resolver.cache != nil && c.resolver.cacheMaxStale > 0 {
+		c.Stream.SetReadDeadline(time.Now().Add(quicStaleReadTimeout))
+	}
+
+	n, err := c.Stream.Read(p)
+	if err != nil {
+		if c.resolver.cache != nil && c.query != nil {
+			if answer, stale := c.resolver.cache.getStale(c.query); stale {
+				go c.resolver.backgroundRefresh(c.query)
+				c.pending = newFrameReader(answer)
+				return c.pending.Read(p)
+			}
+		}
+		return n, err
+	}
+
+	// The caller's net.Resolver issues the length prefix and body as
+	// separate Read calls, so the answer is reassembled in recvBuf rather
+	// than assumed whole within a single p; p is relayed to the caller
+	// unmodified either way.
+	if c.resolver.cache != nil && c.query != nil {
+		c.recvBuf = append(c.recvBuf, p[:n]...)
+		if len(c.recvBuf) >= 2 {
+			if want := int(binary.BigEndian.Uint16(c.recvBuf)); len(c.recvBuf) >= 2+want {
+				c.resolver.cache.put(c.query, c.recvBuf[2:2+want])
+			}
+		}
+	}
+	return n, err
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr  { return nil }
+func (c *quicStreamConn) RemoteAddr() net.Addr { return nil }
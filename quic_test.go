@@ -0,0 +1,67 @@
+package dns_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ncruces/go-dns"
+)
+
+func TestNewQUICResolver(t *testing.T) {
+	// DNS-over-QUIC Public Resolvers
+	tests := map[string]struct {
+		server string
+		opts   []dns.QUICOption
+	}{
+		"AdGuard": {server: "dns.adguard.com"},
+		"Cloudflare": {
+			server: "cloudflare-dns.com",
+			opts:   []dns.QUICOption{dns.QUICAddresses("1.1.1.1", "1.0.0.1", "2606:4700:4700::1111", "2606:4700:4700::1001")},
+		},
+		"NextDNS": {server: "dns.nextdns.io"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := dns.NewQUICResolver(tc.server, tc.opts...)
+			if err != nil {
+				t.Fatalf("NewQUICResolver() error = %v", err)
+				return
+			}
+
+			ips, err := r.LookupIPAddr(context.TODO(), "one.one.one.one")
+			if err != nil {
+				t.Fatalf("LookupIPAddr() error = %v", err)
+				return
+			}
+
+			if !checkIPAddrs(ips, "1.1.1.1", "1.0.0.1", "2606:4700:4700::1111", "2606:4700:4700::1001") {
+				t.Errorf("LookupIPAddr() got = %v", ips)
+			}
+		})
+	}
+
+	t.Run("Cache", func(t *testing.T) {
+		r, err := dns.NewQUICResolver("dns.adguard.com", dns.QUICCache())
+		if err != nil {
+			t.Fatalf("NewQUICResolver() error = %v", err)
+			return
+		}
+
+		a, err := r.LookupIPAddr(context.TODO(), "one.one.one.one")
+		if err != nil {
+			t.Fatalf("LookupIPAddr() error = %v", err)
+			return
+		}
+
+		b, err := r.LookupIPAddr(context.TODO(), "one.one.one.one")
+		if err != nil {
+			t.Fatalf("LookupIPAddr() error = %v", err)
+			return
+		}
+
+		if !check(a, b) {
+			t.Errorf("LookupIPAddr() want = %v, got = %v", a, b)
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// RaceOption overrides default behavior of NewRacingResolver.
+type RaceOption func(*raceResolver)
+
+// RaceStagger sets the delay between starting each successive resolver in
+// the send plan (resolvers[0] always starts at t=0). The default is 100ms.
+func RaceStagger(d time.Duration) RaceOption {
+	return func(r *raceResolver) { r.stagger = d }
+}
+
+// RacePreferIPv6 doubles the stagger delay for A lookups, so every resolver
+// after resolvers[0] starts later for an A lookup than for an AAAA lookup
+// of the same host, similar to the Happy Eyeballs address sorting in RFC
+// 8305. resolvers[0] starts at t=0 for both.
+func RacePreferIPv6() RaceOption {
+	return func(r *raceResolver) { r.preferIPv6 = true }
+}
+
+// RaceHedgeOnTimeout starts every resolver in the send plan that has not
+// started yet, without waiting out its remaining stagger delay, once
+// threshold has passed since the query started and no answer has won the
+// race. It does not start them one at a time: all of them start together.
+func RaceHedgeOnTimeout(threshold time.Duration) RaceOption {
+	return func(r *raceResolver) { r.hedge = threshold }
+}
+
+type raceResolver struct {
+	resolvers  []*net.Resolver
+	stagger    time.Duration
+	preferIPv6 bool
+	hedge      time.Duration
+}
+
+// NewRacingResolver combines several resolvers into one fault-tolerant
+// resolver: every lookup is sent to each of resolvers following an explicit,
+// staggered send plan (resolvers[0] at t=0, resolvers[1] at t=stagger, and
+// so on), and the first response to arrive wins, cancelling the remaining
+// in-flight resolvers. This avoids a single stalled upstream blocking the
+// whole lookup. A response wins whatever its RCODE and whether or not it
+// carries answer records: only a resolver that fails with an error is
+// passed over.
+func NewRacingResolver(resolvers []*net.Resolver, opts ...RaceOption) *net.Resolver {
+	r := &raceResolver{resolvers: resolvers, stagger: 100 * time.Millisecond}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return &raceConn{ctx: ctx, network: network, address: address, race: r}, nil
+		},
+	}
+}
+
+// raceConn buffers one query, then performs the race on the first Read, so
+// it can be returned as the net.Conn backing a net.Resolver.Dial call.
+type raceConn struct {
+	ctx     context.Context
+	network string
+	address string
+	race    *raceResolver
+
+	query, answer []byte
+}
+
+func (c *raceConn) Write(p []byte) (int, error) {
+	c.query = append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func (c *raceConn) Read(p []byte) (int, error) {
+	if c.answer == nil {
+		answer, err := c.race.exchange(c.ctx, c.network, c.address, c.query)
+		if err != nil {
+			return 0, err
+		}
+		c.answer = answer
+	}
+	n := copy(p, c.answer)
+	c.answer = c.answer[n:]
+	return n, nil
+}
+
+func (c *raceConn) Close() error                     { return nil }
+func (c *raceConn) LocalAddr() net.Addr              { return nil }
+func (c *raceConn) RemoteAddr() net.Addr             { return nil }
+func (c *raceConn) SetDeadline(time.Time) error      { return nil }
+func (c *raceConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *raceConn) SetWriteDeadline(time.Time) error { return nil }
+
+type raceAnswer struct {
+	answer []byte
+	err    error
+}
+
+// exchange runs query against every resolver per the send plan, returning
+// the first response read without error, whatever its RCODE.
+func (r *raceResolver) exchange(ctx context.Context, network, address string, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stagger := r.stagger
+	if r.preferIPv6 && queryType(query) == dnsmessage.TypeA {
+		stagger += r.stagger
+	}
+
+	hedge := make(chan struct{})
+	if r.hedge > 0 {
+		go func() {
+			select {
+			case <-time.After(r.hedge):
+				close(hedge)
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	results := make(chan raceAnswer, len(r.resolvers))
+	for i, res := range r.resolvers {
+		i, res := i, res
+		go func() {
+			timer := time.NewTimer(time.Duration(i) * stagger)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				results <- raceAnswer{err: ctx.Err()}
+				return
+			case <-timer.C:
+			case <-hedge:
+			}
+
+			dial := res.Dial
+			if dial == nil {
+				var d net.Dialer
+				dial = d.DialContext
+			}
+
+			// raceConn is not a net.PacketConn, so the caller's resolver
+			// always frames its query and expects a framed response, as
+			// over TCP, whatever network it dialed; each racing resolver is
+			// dialed over "tcp" to match.
+			conn, err := dial(ctx, "tcp", address)
+			if err != nil {
+				results <- raceAnswer{err: err}
+				return
+			}
+			defer conn.Close()
+
+			// conn.Write and readFramed below block with no deadline and no
+			// awareness of ctx, so once another resolver wins and cancel()
+			// runs, this goroutine wouldn't otherwise notice until its own
+			// transport timed out on its own. Closing conn on cancellation
+			// is what actually interrupts it.
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+
+			if _, err := conn.Write(query); err != nil {
+				results <- raceAnswer{err: err}
+				return
+			}
+
+			// A single conn.Read isn't guaranteed to return the whole
+			// response on a stream: readFramed reads the length prefix,
+			// then reads exactly that many more bytes. Its unwrapped
+			// result is re-framed so raceConn.Read hands the caller's
+			// resolver the framed response it expects.
+			body, err := readFramed(conn, "tcp")
+			if err != nil {
+				results <- raceAnswer{err: err}
+				return
+			}
+			results <- raceAnswer{answer: frameMessage(body)}
+		}()
+	}
+
+	var lastErr error
+	for range r.resolvers {
+		res := <-results
+		switch {
+		case res.err != nil:
+			lastErr = res.err
+		case len(res.answer) > 0:
+			return res.answer, nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("dns: all resolvers in race failed")
+	}
+	return nil, lastErr
+}
+
+func queryType(query []byte) dnsmessage.Type {
+	var p dnsmessage.Parser
+	if _, err := p.Start(query); err != nil {
+		return 0
+	}
+	q, err := p.Question()
+	if err != nil {
+		return 0
+	}
+	return q.Type
+}
@@ -0,0 +1,41 @@
+package dns_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ncruces/go-dns"
+)
+
+func TestNewRacingResolver(t *testing.T) {
+	// TODO: race against dns.NewTLSResolver/dns.NewDoHResolver once those
+	// transports exist in this tree; for now, race two DoQ resolvers.
+	adguard, err := dns.NewQUICResolver("dns.adguard.com")
+	if err != nil {
+		t.Fatalf("NewQUICResolver() error = %v", err)
+		return
+	}
+
+	cloudflare, err := dns.NewQUICResolver("cloudflare-dns.com",
+		dns.QUICAddresses("1.1.1.1", "1.0.0.1", "2606:4700:4700::1111", "2606:4700:4700::1001"))
+	if err != nil {
+		t.Fatalf("NewQUICResolver() error = %v", err)
+		return
+	}
+
+	r := dns.NewRacingResolver([]*net.Resolver{adguard, cloudflare},
+		dns.RaceStagger(50*time.Millisecond),
+		dns.RaceHedgeOnTimeout(500*time.Millisecond))
+
+	ips, err := r.LookupIPAddr(context.TODO(), "one.one.one.one")
+	if err != nil {
+		t.Fatalf("LookupIPAddr() error = %v", err)
+		return
+	}
+
+	if !checkIPAddrs(ips, "1.1.1.1", "1.0.0.1", "2606:4700:4700::1111", "2606:4700:4700::1001") {
+		t.Errorf("LookupIPAddr() got = %v", ips)
+	}
+}